@@ -0,0 +1,129 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Bridge translates MCP tool declarations and calls between the XML text
+// form used on text-only channels and the native tool_use/tool_calls form
+// used by upstream providers that support function calling (Anthropic,
+// OpenAI, ...). It lets the same mcp__* tool set be advertised either way
+// without duplicating prompt engineering per provider.
+type Bridge struct {
+	mcpTools map[string]McpTool
+}
+
+// NewBridge creates a Bridge for the given set of MCP tools.
+func NewBridge(mcpTools []McpTool) *Bridge {
+	m := make(map[string]McpTool, len(mcpTools))
+	for _, t := range mcpTools {
+		if IsMcpToolName(t.Name) {
+			m[t.Name] = t
+		}
+	}
+	return &Bridge{mcpTools: m}
+}
+
+// PrepareOutgoingTools decides what to send upstream for a request that
+// declares declaredTools. When nativeSupported is true, mcp__* tools are
+// kept in the returned tools array and systemPrompt is empty. When it is
+// false, mcp__* tools are stripped from the returned array and
+// systemPrompt carries the XML instructions/tool list that replace them.
+// Non-MCP tools are always passed through untouched.
+func (b *Bridge) PrepareOutgoingTools(declaredTools []McpTool, nativeSupported bool) (kept []McpTool, systemPrompt string) {
+	var mcpTools []McpTool
+	for _, t := range declaredTools {
+		if IsMcpToolName(t.Name) {
+			mcpTools = append(mcpTools, t)
+			if nativeSupported {
+				kept = append(kept, t)
+			}
+			continue
+		}
+		kept = append(kept, t)
+	}
+	if !nativeSupported {
+		systemPrompt = BuildMcpXmlSystemPrompt(mcpTools)
+	}
+	return kept, systemPrompt
+}
+
+// ToolUseBlock is a provider-agnostic view of a native tool_use /
+// tool_calls entry found in a model response.
+type ToolUseBlock struct {
+	ID    string
+	Name  string
+	Input map[string]interface{}
+}
+
+// RewriteNativeToolUse converts a native tool_use block for a registered
+// mcp__* tool into the <mcp__server__tool id="...">{...}</mcp__server__tool>
+// XML form expected by text-only downstream clients. The upstream-assigned
+// ID is embedded as an id attribute so TryParseMcpToolCallXml (and the
+// repair/streaming parsers) recover the original ID instead of minting a
+// new one when the XML is re-parsed; Anthropic/OpenAI require the next
+// request's tool_result.tool_use_id to match it exactly. ok is false when
+// the block does not name a registered mcp__* tool, in which case the
+// block should be passed through unchanged.
+func (b *Bridge) RewriteNativeToolUse(block ToolUseBlock) (xml string, ok bool) {
+	if !IsMcpToolName(block.Name) {
+		return "", false
+	}
+	if _, known := b.mcpTools[block.Name]; !known {
+		return "", false
+	}
+	input := block.Input
+	if input == nil {
+		input = map[string]interface{}{}
+	}
+	jsonBytes, err := json.Marshal(input)
+	if err != nil {
+		return "", false
+	}
+	if block.ID == "" {
+		return fmt.Sprintf("<%s>%s</%s>", block.Name, string(jsonBytes), block.Name), true
+	}
+	return fmt.Sprintf("<%s id=%s>%s</%s>", block.Name, strconv.Quote(block.ID), string(jsonBytes), block.Name), true
+}
+
+// ToolResultBlock is a provider-agnostic tool_result content block keyed
+// by the tool_use_id it answers.
+type ToolResultBlock struct {
+	ToolUseID string
+	Content   string
+	IsError   bool
+}
+
+// ParseMcpToolResultXml converts an incoming <mcp_tool_result> XML block
+// (as produced by BuildMcpToolResultXml) back into a tool_result content
+// block so it can be spliced into a native request for an upstream that
+// expects tool_result entries instead of XML text.
+func (b *Bridge) ParseMcpToolResultXml(xmlText string) (ToolResultBlock, bool) {
+	const openTag = "<mcp_tool_result>"
+	const closeTag = "</mcp_tool_result>"
+
+	start := strings.Index(xmlText, openTag)
+	end := strings.Index(xmlText, closeTag)
+	if start == -1 || end == -1 || end < start {
+		return ToolResultBlock{}, false
+	}
+	inner := xmlText[start+len(openTag) : end]
+
+	var payload struct {
+		ToolUseID string `json:"tool_use_id"`
+		Result    string `json:"result"`
+		IsError   bool   `json:"is_error"`
+	}
+	if err := json.Unmarshal([]byte(inner), &payload); err != nil {
+		return ToolResultBlock{}, false
+	}
+
+	return ToolResultBlock{
+		ToolUseID: payload.ToolUseID,
+		Content:   payload.Result,
+		IsError:   payload.IsError,
+	}, true
+}
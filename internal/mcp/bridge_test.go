@@ -0,0 +1,74 @@
+package mcp
+
+import "testing"
+
+// TestRewriteNativeToolUsePreservesID verifies that the upstream-assigned
+// tool_use_id survives a rewrite to XML and back, since Anthropic/OpenAI
+// require the tool_result.tool_use_id in the next request to match the
+// original tool_use.id from the same turn exactly.
+func TestRewriteNativeToolUsePreservesID(t *testing.T) {
+	b := NewBridge([]McpTool{{Name: "mcp__fs__read"}})
+
+	block := ToolUseBlock{
+		ID:    "toolu_upstream_123",
+		Name:  "mcp__fs__read",
+		Input: map[string]interface{}{"path": "a.txt"},
+	}
+
+	xml, ok := b.RewriteNativeToolUse(block)
+	if !ok {
+		t.Fatalf("RewriteNativeToolUse returned ok=false")
+	}
+
+	call, ok := TryParseMcpToolCallXml(xml, "mcp__fs__read")
+	if !ok {
+		t.Fatalf("TryParseMcpToolCallXml failed to parse %q", xml)
+	}
+	if call.ID != block.ID {
+		t.Errorf("ID = %q, want %q (re-parsed XML minted a new id instead of preserving the upstream one)", call.ID, block.ID)
+	}
+	if call.Input["path"] != "a.txt" {
+		t.Errorf("Input[\"path\"] = %v, want %q", call.Input["path"], "a.txt")
+	}
+}
+
+// TestRewriteNativeToolUseNoID confirms that a block without an upstream
+// ID still round-trips (a new ID is minted, which is fine since there was
+// no original to preserve).
+func TestRewriteNativeToolUseNoID(t *testing.T) {
+	b := NewBridge([]McpTool{{Name: "mcp__fs__read"}})
+
+	block := ToolUseBlock{Name: "mcp__fs__read", Input: map[string]interface{}{"path": "a.txt"}}
+
+	xml, ok := b.RewriteNativeToolUse(block)
+	if !ok {
+		t.Fatalf("RewriteNativeToolUse returned ok=false")
+	}
+
+	call, ok := TryParseMcpToolCallXml(xml, "mcp__fs__read")
+	if !ok {
+		t.Fatalf("TryParseMcpToolCallXml failed to parse %q", xml)
+	}
+	if call.ID == "" {
+		t.Errorf("ID is empty, want a generated id")
+	}
+}
+
+// TestParseAttributeFormSkipsIDAttribute ensures the reserved id attribute
+// used to carry the upstream tool_use_id is never leaked into a tool's
+// Input map when the repair parser falls back to attribute form.
+func TestParseAttributeFormSkipsIDAttribute(t *testing.T) {
+	call, parseErr := TryParseMcpToolCallXmlRepair(`<mcp__fs__read id="toolu_1" path="a.txt"/>`, "mcp__fs__read", DefaultRepairOptions())
+	if parseErr != nil {
+		t.Fatalf("TryParseMcpToolCallXmlRepair failed: %v", parseErr)
+	}
+	if call.ID != "toolu_1" {
+		t.Errorf("ID = %q, want %q", call.ID, "toolu_1")
+	}
+	if _, leaked := call.Input["id"]; leaked {
+		t.Errorf("Input contains reserved \"id\" key: %v", call.Input)
+	}
+	if call.Input["path"] != "a.txt" {
+		t.Errorf("Input[\"path\"] = %v, want %q", call.Input["path"], "a.txt")
+	}
+}
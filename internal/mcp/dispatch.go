@@ -0,0 +1,74 @@
+package mcp
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// ToolResult is the outcome of executing a single MCP tool call.
+type ToolResult struct {
+	ToolUseID string
+	Name      string
+	Result    string
+	IsError   bool
+}
+
+// ToolExecutor runs a single MCP tool call and returns its result.
+type ToolExecutor func(ctx context.Context, call McpToolCall) ToolResult
+
+// Dispatcher executes a ToolBatch, running its independent calls
+// concurrently and returning their results keyed by tool_use_id order.
+type Dispatcher interface {
+	Execute(ctx context.Context, batch ToolBatch) []ToolResult
+}
+
+// WorkerPoolDispatcher is a Dispatcher that runs up to Workers calls of a
+// ToolBatch concurrently via a bounded worker pool.
+type WorkerPoolDispatcher struct {
+	Run     ToolExecutor
+	Workers int
+}
+
+// NewWorkerPoolDispatcher creates a WorkerPoolDispatcher bounded to at
+// most workers concurrent calls (at least 1), executing each call with
+// run.
+func NewWorkerPoolDispatcher(run ToolExecutor, workers int) *WorkerPoolDispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+	return &WorkerPoolDispatcher{Run: run, Workers: workers}
+}
+
+// Execute runs batch.Calls concurrently, bounded by d.Workers, and
+// returns their results in the same order as batch.Calls (by
+// tool_use_id), regardless of completion order.
+func (d *WorkerPoolDispatcher) Execute(ctx context.Context, batch ToolBatch) []ToolResult {
+	results := make([]ToolResult, len(batch.Calls))
+	sem := make(chan struct{}, d.Workers)
+	var wg sync.WaitGroup
+
+	for i, call := range batch.Calls {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, call McpToolCall) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = d.Run(ctx, call)
+		}(i, call)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// BuildMcpToolResultsXml emits one <mcp_tool_result> per result, in the
+// same order as results, so the model can match each one back to the
+// tool_use_id of the call it answers.
+func BuildMcpToolResultsXml(results []ToolResult) string {
+	parts := make([]string, len(results))
+	for i, r := range results {
+		parts[i] = BuildMcpToolResultXml(r.Name, r.ToolUseID, r.Result, r.IsError)
+	}
+	return strings.Join(parts, "\n")
+}
@@ -0,0 +1,83 @@
+package mcp
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestWorkerPoolDispatcherPreservesOrder verifies that Execute returns
+// results in the same order as batch.Calls regardless of which call
+// finishes first, so callers can correlate results by index.
+func TestWorkerPoolDispatcherPreservesOrder(t *testing.T) {
+	run := func(ctx context.Context, call McpToolCall) ToolResult {
+		if call.Name == "slow" {
+			time.Sleep(20 * time.Millisecond)
+		}
+		return ToolResult{ToolUseID: call.ID, Name: call.Name, Result: call.Name}
+	}
+	d := NewWorkerPoolDispatcher(run, 4)
+
+	batch := ToolBatch{Calls: []McpToolCall{
+		{Name: "slow", ID: "1"},
+		{Name: "fast", ID: "2"},
+		{Name: "fast", ID: "3"},
+	}}
+
+	results := d.Execute(context.Background(), batch)
+	if len(results) != 3 {
+		t.Fatalf("len(results) = %d, want 3", len(results))
+	}
+	for i, want := range []string{"slow", "fast", "fast"} {
+		if results[i].Name != want {
+			t.Errorf("results[%d].Name = %q, want %q", i, results[i].Name, want)
+		}
+	}
+}
+
+// TestWorkerPoolDispatcherBoundsConcurrency checks that Execute never runs
+// more than Workers calls at once.
+func TestWorkerPoolDispatcherBoundsConcurrency(t *testing.T) {
+	var current, max int32
+	run := func(ctx context.Context, call McpToolCall) ToolResult {
+		n := atomic.AddInt32(&current, 1)
+		for {
+			m := atomic.LoadInt32(&max)
+			if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&current, -1)
+		return ToolResult{}
+	}
+	d := NewWorkerPoolDispatcher(run, 2)
+
+	calls := make([]McpToolCall, 8)
+	d.Execute(context.Background(), ToolBatch{Calls: calls})
+
+	if max > 2 {
+		t.Errorf("observed concurrency %d, want at most 2", max)
+	}
+}
+
+// TestBuildMcpToolResultsXmlOrdersByCall confirms each result is rendered
+// with its own tool_use_id, in the same order the results were given, so
+// the model can match each <mcp_tool_result> back to its call.
+func TestBuildMcpToolResultsXmlOrdersByCall(t *testing.T) {
+	xml := BuildMcpToolResultsXml([]ToolResult{
+		{ToolUseID: "a", Name: "mcp__fs__read", Result: "one"},
+		{ToolUseID: "b", Name: "mcp__fs__write", Result: "two", IsError: true},
+	})
+
+	aIdx := strings.Index(xml, `"tool_use_id":"a"`)
+	bIdx := strings.Index(xml, `"tool_use_id":"b"`)
+	if aIdx == -1 || bIdx == -1 || aIdx > bIdx {
+		t.Fatalf("xml = %q, want tool_use_id \"a\" before \"b\"", xml)
+	}
+	if !strings.Contains(xml, `"is_error":true`) {
+		t.Errorf("xml = %q, want the second result's is_error to be true", xml)
+	}
+}
@@ -0,0 +1,443 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// MCP_ENCODING_ENV is the environment variable name that selects which
+// CallEncoding(s) are active. One of "xml", "json", "yaml", or "auto".
+// Unset or any other value behaves like "auto". Replaces the old
+// AG2API_MCP_XML_ENABLED on/off switch now that XML is one of several
+// encodings.
+const MCP_ENCODING_ENV = "AG2API_MCP_ENCODING"
+
+// CallEncoding is a pluggable text syntax for how the model represents an
+// MCP tool call, and how a tool result is encoded back to it. Different
+// upstream models are reliable at different syntaxes (raw XML, fenced
+// JSON, YAML, ...); multiple encodings can be active at once so the model
+// can use whichever it's best at.
+type CallEncoding interface {
+	// Name identifies the encoding; matches an AG2API_MCP_ENCODING value.
+	Name() string
+	// SystemPromptFragment documents this encoding's call syntax and
+	// lists the available MCP tools, for concatenation into the system
+	// prompt alongside other active encodings.
+	SystemPromptFragment(tools []McpTool) string
+	// Scan looks for the first complete tool call of this encoding inside
+	// buffer, restricted to names present in toolNames. ok is false if
+	// there is no complete call yet.
+	Scan(buffer string, toolNames map[string]bool) (matchStart, matchEnd int, call *McpToolCall, ok bool)
+	// EncodeResult renders a tool result back into this encoding's text
+	// form.
+	EncodeResult(name, id, result string, isError bool) string
+}
+
+// fencedEncoding is implemented by encodings that wrap a call in a pair
+// of markdown code-fence markers (jsonFenceEncoding, yamlEncoding), so
+// XmlStreamParser.PushText can locate a call's boundaries in the stream
+// without needing a CallEncoding-level Scan on data that hasn't fully
+// arrived yet. xmlEncoding intentionally does not implement this.
+type fencedEncoding interface {
+	fenceOpen() string
+	fenceClose() string
+}
+
+// SelectedEncoding returns the CallEncoding(s) enabled by
+// AG2API_MCP_ENCODING. "auto" (the default) enables every encoding, and
+// callers such as ScanAny run them concurrently and take whichever
+// matches first.
+func SelectedEncoding() []CallEncoding {
+	raw := strings.ToLower(strings.TrimSpace(os.Getenv(MCP_ENCODING_ENV)))
+	switch raw {
+	case "xml":
+		return []CallEncoding{xmlEncoding{}}
+	case "json":
+		return []CallEncoding{jsonFenceEncoding{}}
+	case "yaml":
+		return []CallEncoding{yamlEncoding{}}
+	default:
+		return []CallEncoding{xmlEncoding{}, jsonFenceEncoding{}, yamlEncoding{}}
+	}
+}
+
+// ScanAny runs every encoding's Scan concurrently against buffer and
+// returns the one whose match starts earliest, breaking ties by the
+// order encodings appear in. ok is false if none of them match.
+func ScanAny(encodings []CallEncoding, buffer string, toolNames map[string]bool) (matchStart, matchEnd int, call *McpToolCall, enc CallEncoding, ok bool) {
+	type scanResult struct {
+		start, end int
+		call       *McpToolCall
+		ok         bool
+	}
+	results := make([]scanResult, len(encodings))
+
+	var wg sync.WaitGroup
+	for i, e := range encodings {
+		wg.Add(1)
+		go func(i int, e CallEncoding) {
+			defer wg.Done()
+			s, en, c, matched := e.Scan(buffer, toolNames)
+			results[i] = scanResult{start: s, end: en, call: c, ok: matched}
+		}(i, e)
+	}
+	wg.Wait()
+
+	best := -1
+	for i, r := range results {
+		if !r.ok {
+			continue
+		}
+		if best == -1 || r.start < results[best].start {
+			best = i
+		}
+	}
+	if best == -1 {
+		return 0, 0, nil, nil, false
+	}
+	r := results[best]
+	return r.start, r.end, r.call, encodings[best], true
+}
+
+// mcpToolLines renders the "name / description / input_schema" listing
+// shared by every encoding's SystemPromptFragment. Callers are expected
+// to have already narrowed tools to the set that should be advertised
+// (e.g. via Registry, which may rename mcp__* tools to a group alias).
+func mcpToolLines(mcpTools []McpTool) []string {
+	var lines []string
+	for _, tool := range mcpTools {
+		line := fmt.Sprintf("- %s", tool.Name)
+		if tool.Description != "" {
+			line += ": " + tool.Description
+		}
+		lines = append(lines, line)
+		if tool.InputSchema != "" {
+			lines = append(lines, "  input_schema: "+tool.InputSchema)
+		}
+	}
+	return lines
+}
+
+// xmlEncoding is the original <mcp__server__tool>{...}</mcp__server__tool>
+// syntax.
+type xmlEncoding struct{}
+
+func (xmlEncoding) Name() string { return "xml" }
+
+func (xmlEncoding) SystemPromptFragment(mcpTools []McpTool) string {
+	lines := []string{
+		"==== MCP XML 工具调用（仅 mcp__*） ====",
+		"当你需要调用名称以 `mcp__` 开头的 MCP 工具时：",
+		"1) 不要使用 tool_use/function_call（因为该链路会报错）。",
+		"2) 直接输出一个 XML 块（只输出 XML，不要解释/不要 markdown）。",
+		"3) XML 的根标签必须是工具名，内容必须是 JSON（对象/数组），表示该工具的入参。",
+		"",
+		"示例：",
+		`<mcp__server__tool>{"arg":"value"}</mcp__server__tool>`,
+		"",
+		"工具执行完成后，我会把结果以如下 XML 返回给你：",
+		`<mcp_tool_result>{"name":"mcp__server__tool","tool_use_id":"toolu_xxx","result":"...","is_error":false}</mcp_tool_result>`,
+		"",
+		"当 is_error 为 true 时，表示该工具执行失败，result 内容为错误信息。",
+		"",
+		"如果你需要并行调用多个相互独立的工具，可以在同一次回复中依次输出多个 XML 块，",
+		"就像 Anthropic 的 parallel tool use 和 OpenAI 的 tool_calls 数组一样；每个块会按出现顺序对应一次工具结果。",
+		"",
+		"对于非 `mcp__*` 工具：继续使用正常的工具调用机制。",
+		"",
+		"可用 MCP 工具列表（name / description / input_schema）：",
+	}
+	lines = append(lines, mcpToolLines(mcpTools)...)
+	return strings.Join(lines, "\n")
+}
+
+func (xmlEncoding) Scan(buffer string, toolNames map[string]bool) (int, int, *McpToolCall, bool) {
+	start, name := findFirstOpenTag(buffer, toolNames)
+	if start == -1 {
+		return 0, 0, nil, false
+	}
+	needle := "</" + name
+	closeIdx := strings.Index(buffer[start:], needle)
+	if closeIdx == -1 {
+		return 0, 0, nil, false
+	}
+	closeIdx += start
+	after := closeIdx + len(needle)
+	gt := strings.Index(buffer[after:], ">")
+	if gt == -1 {
+		return 0, 0, nil, false
+	}
+	end := after + gt + 1
+
+	call, ok := TryParseMcpToolCallXml(buffer[start:end], name)
+	if !ok {
+		return 0, 0, nil, false
+	}
+	return start, end, call, true
+}
+
+func (xmlEncoding) EncodeResult(name, id, result string, isError bool) string {
+	return BuildMcpToolResultXml(name, id, result, isError)
+}
+
+// scanOpenTag implements streamableEncoding: it reports as soon as a
+// tool's opening tag alone has arrived, so XmlStreamParser.PushText can
+// start emitting tool_delta chunks for the body instead of waiting for
+// ScanAny to see the whole call like every other encoding.
+func (xmlEncoding) scanOpenTag(buffer string, toolNames map[string]bool) (start, tagEnd int, name, id string, ok bool) {
+	start, name = findFirstOpenTag(buffer, toolNames)
+	if start == -1 {
+		return 0, 0, "", "", false
+	}
+	gt := strings.Index(buffer[start:], ">")
+	if gt == -1 {
+		return 0, 0, "", "", false // opening tag not fully arrived yet
+	}
+	tagEnd = start + gt + 1
+
+	id = MakeToolUseID(name)
+	if explicit, explicitOK := extractTagID(buffer[start:tagEnd]); explicitOK && explicit != "" {
+		id = explicit
+	}
+	return start, tagEnd, name, id, true
+}
+
+// recoverFailedCall implements recoverableEncoding: once a tool's open and
+// close tags have both arrived but Scan's strict parse failed, this runs
+// the tolerant repair parser (code fences, HTML entities, loose JSON,
+// attribute form) over the same span before giving up on it, returning a
+// ParseError instead of a call when even that can't make sense of it.
+func (xmlEncoding) recoverFailedCall(buffer string, toolNames map[string]bool) (matchStart, matchEnd int, call *McpToolCall, parseErr *ParseError, ok bool) {
+	start, name := findFirstOpenTag(buffer, toolNames)
+	if start == -1 {
+		return 0, 0, nil, nil, false
+	}
+	end := closeTagEndIndex(buffer[start:], name)
+	if end == -1 {
+		return 0, 0, nil, nil, false
+	}
+	end += start
+
+	repaired, repairErr := TryParseMcpToolCallXmlRepair(buffer[start:end], name, DefaultRepairOptions())
+	if repairErr != nil {
+		return start, end, nil, repairErr, true
+	}
+	return start, end, repaired, nil, true
+}
+
+func findFirstOpenTag(buffer string, toolNames map[string]bool) (int, string) {
+	best := -1
+	bestName := ""
+	for name := range toolNames {
+		open := "<" + name
+		idx := strings.Index(buffer, open)
+		if idx == -1 {
+			continue
+		}
+		if idx+len(open) < len(buffer) {
+			ch := buffer[idx+len(open)]
+			if ch != '>' && ch != '/' && ch != ' ' && ch != '\t' && ch != '\n' && ch != '\r' {
+				continue
+			}
+		}
+		if best == -1 || idx < best {
+			best = idx
+			bestName = name
+		}
+	}
+	return best, bestName
+}
+
+// jsonFenceEncoding represents a call as a fenced ```tool_call block
+// whose body is a JSON object: {"name":"mcp__...","input":{...}}.
+type jsonFenceEncoding struct{}
+
+const jsonFenceOpen = "```tool_call"
+const jsonFenceClose = "```"
+
+func (jsonFenceEncoding) Name() string { return "json" }
+
+func (jsonFenceEncoding) SystemPromptFragment(mcpTools []McpTool) string {
+	lines := []string{
+		"==== MCP JSON 工具调用（仅 mcp__*） ====",
+		"当你需要调用名称以 `mcp__` 开头的 MCP 工具时，可以输出一个 ```tool_call 代码块，内容是 JSON：",
+		"",
+		"```tool_call",
+		`{"name":"mcp__server__tool","input":{"arg":"value"}}`,
+		"```",
+		"",
+		"可用 MCP 工具列表（name / description / input_schema）：",
+	}
+	lines = append(lines, mcpToolLines(mcpTools)...)
+	return strings.Join(lines, "\n")
+}
+
+func (jsonFenceEncoding) Scan(buffer string, toolNames map[string]bool) (int, int, *McpToolCall, bool) {
+	start := strings.Index(buffer, jsonFenceOpen)
+	if start == -1 {
+		return 0, 0, nil, false
+	}
+	bodyStart := start + len(jsonFenceOpen)
+	closeIdx := strings.Index(buffer[bodyStart:], jsonFenceClose)
+	if closeIdx == -1 {
+		return 0, 0, nil, false
+	}
+	end := bodyStart + closeIdx + len(jsonFenceClose)
+	body := strings.TrimSpace(buffer[bodyStart : bodyStart+closeIdx])
+
+	var payload struct {
+		Name  string                 `json:"name"`
+		Input map[string]interface{} `json:"input"`
+	}
+	if err := json.Unmarshal([]byte(body), &payload); err != nil || !toolNames[payload.Name] {
+		return 0, 0, nil, false
+	}
+	if payload.Input == nil {
+		payload.Input = make(map[string]interface{})
+	}
+	return start, end, &McpToolCall{Name: payload.Name, Input: payload.Input, ID: MakeToolUseID(payload.Name)}, true
+}
+
+func (jsonFenceEncoding) fenceOpen() string  { return jsonFenceOpen }
+func (jsonFenceEncoding) fenceClose() string { return jsonFenceClose }
+
+func (jsonFenceEncoding) EncodeResult(name, id, result string, isError bool) string {
+	payload := map[string]interface{}{
+		"name":        name,
+		"tool_use_id": id,
+		"result":      result,
+		"is_error":    isError,
+	}
+	jsonBytes, _ := json.Marshal(payload)
+	return "```tool_result\n" + string(jsonBytes) + "\n```"
+}
+
+// yamlEncoding represents a call as a fenced ```tool_call_yaml block with
+// a "name" key and a flat "input" mapping. It only supports the flat
+// subset of YAML MCP tool inputs actually need, not the full spec.
+type yamlEncoding struct{}
+
+const yamlFenceOpen = "```tool_call_yaml"
+const yamlFenceClose = "```"
+
+func (yamlEncoding) Name() string { return "yaml" }
+
+func (yamlEncoding) SystemPromptFragment(mcpTools []McpTool) string {
+	lines := []string{
+		"==== MCP YAML 工具调用（仅 mcp__*） ====",
+		"当你需要调用名称以 `mcp__` 开头的 MCP 工具时，可以输出一个 ```tool_call_yaml 代码块：",
+		"",
+		"```tool_call_yaml",
+		"name: mcp__server__tool",
+		"input:",
+		"  arg: value",
+		"```",
+		"",
+		"可用 MCP 工具列表（name / description / input_schema）：",
+	}
+	lines = append(lines, mcpToolLines(mcpTools)...)
+	return strings.Join(lines, "\n")
+}
+
+func (yamlEncoding) Scan(buffer string, toolNames map[string]bool) (int, int, *McpToolCall, bool) {
+	start := strings.Index(buffer, yamlFenceOpen)
+	if start == -1 {
+		return 0, 0, nil, false
+	}
+	bodyStart := start + len(yamlFenceOpen)
+	closeIdx := strings.Index(buffer[bodyStart:], yamlFenceClose)
+	if closeIdx == -1 {
+		return 0, 0, nil, false
+	}
+	end := bodyStart + closeIdx + len(yamlFenceClose)
+	body := buffer[bodyStart : bodyStart+closeIdx]
+
+	name, input, ok := parseToolCallYaml(body)
+	if !ok || !toolNames[name] {
+		return 0, 0, nil, false
+	}
+	return start, end, &McpToolCall{Name: name, Input: input, ID: MakeToolUseID(name)}, true
+}
+
+func (yamlEncoding) fenceOpen() string  { return yamlFenceOpen }
+func (yamlEncoding) fenceClose() string { return yamlFenceClose }
+
+func (yamlEncoding) EncodeResult(name, id, result string, isError bool) string {
+	var b strings.Builder
+	b.WriteString("```tool_result_yaml\n")
+	b.WriteString("name: " + name + "\n")
+	b.WriteString("tool_use_id: " + id + "\n")
+	b.WriteString("result: " + yamlQuoteString(result) + "\n")
+	b.WriteString(fmt.Sprintf("is_error: %t\n", isError))
+	b.WriteString("```")
+	return b.String()
+}
+
+// parseToolCallYaml reads the flat "name"/"input" shape produced by
+// yamlEncoding.SystemPromptFragment: a top-level "name: ..." key and a
+// top-level "input:" key followed by one level of indented "key: value"
+// pairs.
+func parseToolCallYaml(body string) (string, map[string]interface{}, bool) {
+	name := ""
+	input := make(map[string]interface{})
+	inInput := false
+
+	for _, rawLine := range strings.Split(body, "\n") {
+		line := strings.TrimRight(rawLine, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		indented := line[0] == ' ' || line[0] == '\t'
+		trimmed := strings.TrimSpace(line)
+
+		if !indented {
+			inInput = trimmed == "input:"
+			if inInput {
+				continue
+			}
+			if key, val, ok := splitYamlKeyValue(trimmed); ok && key == "name" {
+				name = val
+			}
+			continue
+		}
+		if !inInput {
+			continue
+		}
+		if key, val, ok := splitYamlKeyValue(trimmed); ok {
+			input[key] = coerceAttributeValue(trimYamlQuotes(val))
+		}
+	}
+
+	if name == "" {
+		return "", nil, false
+	}
+	return name, input, true
+}
+
+func splitYamlKeyValue(line string) (key, value string, ok bool) {
+	idx := strings.Index(line, ":")
+	if idx == -1 {
+		return "", "", false
+	}
+	key = strings.TrimSpace(line[:idx])
+	value = strings.TrimSpace(line[idx+1:])
+	return key, value, key != ""
+}
+
+func trimYamlQuotes(s string) string {
+	if len(s) >= 2 {
+		if (s[0] == '"' && s[len(s)-1] == '"') || (s[0] == '\'' && s[len(s)-1] == '\'') {
+			return s[1 : len(s)-1]
+		}
+	}
+	return s
+}
+
+func yamlQuoteString(s string) string {
+	escaped := strings.ReplaceAll(s, `"`, `\"`)
+	escaped = strings.ReplaceAll(escaped, "\n", "\\n")
+	return `"` + escaped + `"`
+}
@@ -0,0 +1,84 @@
+package mcp
+
+import "testing"
+
+// TestPushTextRecognizesJsonFenceCall verifies that a ```tool_call fenced
+// JSON block is actually recognized by XmlStreamParser.PushText when the
+// parser is configured with the json encoding, not just advertised in the
+// system prompt and otherwise ignored.
+func TestPushTextRecognizesJsonFenceCall(t *testing.T) {
+	p := NewXmlStreamParserWithOptions([]string{"mcp__fs__write"}, ParserOptions{
+		Encodings: []CallEncoding{jsonFenceEncoding{}},
+	})
+
+	results := p.PushText("before\n```tool_call\n" + `{"name":"mcp__fs__write","input":{"path":"a.txt"}}` + "\n```\nafter")
+
+	var gotTool bool
+	for _, r := range results {
+		if r.Type == "tool" {
+			gotTool = true
+			if r.Name != "mcp__fs__write" {
+				t.Errorf("Name = %q, want %q", r.Name, "mcp__fs__write")
+			}
+			if r.Input["path"] != "a.txt" {
+				t.Errorf("Input[\"path\"] = %v, want %q", r.Input["path"], "a.txt")
+			}
+		}
+	}
+	if !gotTool {
+		t.Fatalf("results = %+v, want a tool result for the fenced JSON call", results)
+	}
+}
+
+// TestPushTextRecognizesYamlFenceCall is the yaml-encoding analog of
+// TestPushTextRecognizesJsonFenceCall, and also guards against
+// jsonFenceOpen's "```tool_call" prefix shadowing yamlFenceOpen's
+// "```tool_call_yaml".
+func TestPushTextRecognizesYamlFenceCall(t *testing.T) {
+	p := NewXmlStreamParserWithOptions([]string{"mcp__fs__write"}, ParserOptions{
+		Encodings: SelectedEncoding(),
+	})
+
+	results := p.PushText("```tool_call_yaml\nname: mcp__fs__write\ninput:\n  path: a.txt\n```")
+
+	var gotTool bool
+	for _, r := range results {
+		if r.Type == "tool" {
+			gotTool = true
+			if r.Name != "mcp__fs__write" {
+				t.Errorf("Name = %q, want %q", r.Name, "mcp__fs__write")
+			}
+			if r.Input["path"] != "a.txt" {
+				t.Errorf("Input[\"path\"] = %v, want %q", r.Input["path"], "a.txt")
+			}
+		}
+	}
+	if !gotTool {
+		t.Fatalf("results = %+v, want a tool result for the fenced YAML call", results)
+	}
+}
+
+// TestPushTextStillStreamsXmlWhenAllEncodingsActive confirms that turning
+// on every encoding (the "auto" default) doesn't regress the XML
+// tool_delta streaming path.
+func TestPushTextStillStreamsXmlWhenAllEncodingsActive(t *testing.T) {
+	p := NewXmlStreamParserWithOptions([]string{"mcp__fs__write"}, ParserOptions{Encodings: SelectedEncoding()})
+
+	var deltas []string
+	for _, r := range p.PushText(`<mcp__fs__write>{"path"`) {
+		if r.Type == "tool_delta" {
+			deltas = append(deltas, r.PartialJSON)
+		}
+	}
+	for _, r := range p.PushText(`:"a.txt"}</mcp__fs__write>`) {
+		if r.Type == "tool_delta" {
+			deltas = append(deltas, r.PartialJSON)
+		}
+		if r.Type == "tool" && r.Input["path"] != "a.txt" {
+			t.Errorf("Input[\"path\"] = %v, want %q", r.Input["path"], "a.txt")
+		}
+	}
+	if len(deltas) == 0 {
+		t.Errorf("got no tool_delta results, want incremental XML streaming to still work")
+	}
+}
@@ -0,0 +1,190 @@
+package mcp
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ToolGroupHeader and ToolGroupQueryParam are the conventional places an
+// HTTP layer can read the group name from to scope a request's MCP tools
+// without recompiling.
+const (
+	ToolGroupHeader     = "X-Mcp-Tool-Group"
+	ToolGroupQueryParam = "mcp_tool_group"
+)
+
+// ResolveGroupName picks the tool group for a request: the header value
+// wins if present, otherwise the query parameter, otherwise "" (meaning
+// Registry falls back to every registered tool).
+func ResolveGroupName(headerValue, queryValue string) string {
+	if headerValue != "" {
+		return headerValue
+	}
+	return queryValue
+}
+
+// ToolGroup restricts and relabels which mcp__* tools are advertised to
+// the model on a given request, so different roles/agents can be given a
+// different tool surface without recompiling.
+type ToolGroup struct {
+	Name string
+	// Include lists the canonical mcp__* tool names this group exposes.
+	// Empty means every registered tool is a candidate before
+	// ExcludePatterns is applied.
+	Include []string
+	// ExcludePatterns are regexps matched against the canonical tool
+	// name; any match removes that tool from the group.
+	ExcludePatterns []string
+	// Aliases maps a canonical tool name (e.g. "mcp__fs__read") to the
+	// short name the model sees in the prompt and should use in its tag
+	// (e.g. "read_file"). Tools without an entry keep their canonical
+	// name.
+	Aliases map[string]string
+}
+
+// resolve filters allTools down to this group's tool set and returns the
+// resulting (possibly aliased) McpTool list, plus a map from each visible
+// name back to the canonical mcp__* name so an incoming call can be
+// translated before dispatch.
+func (g ToolGroup) resolve(allTools []McpTool) (visible []McpTool, aliasToCanonical map[string]string) {
+	var include map[string]bool
+	if len(g.Include) > 0 {
+		include = make(map[string]bool, len(g.Include))
+		for _, name := range g.Include {
+			include[name] = true
+		}
+	}
+
+	excludes := make([]*regexp.Regexp, 0, len(g.ExcludePatterns))
+	for _, pattern := range g.ExcludePatterns {
+		if re, err := regexp.Compile(pattern); err == nil {
+			excludes = append(excludes, re)
+		}
+	}
+
+	aliasToCanonical = make(map[string]string)
+	for _, t := range allTools {
+		if !IsMcpToolName(t.Name) {
+			continue
+		}
+		if include != nil && !include[t.Name] {
+			continue
+		}
+		if matchesAny(excludes, t.Name) {
+			continue
+		}
+
+		visibleName := t.Name
+		if alias, ok := g.Aliases[t.Name]; ok && alias != "" {
+			visibleName = alias
+		}
+		if _, collision := aliasToCanonical[visibleName]; collision {
+			// Two canonical tools mapping to the same visible name would
+			// make the model see one tag for two different tools, and any
+			// call routed through it would silently dispatch to whichever
+			// canonical tool happened to be processed last. Keep the
+			// first claim and drop the rest rather than let that happen
+			// silently.
+			continue
+		}
+		aliasToCanonical[visibleName] = t.Name
+		visible = append(visible, McpTool{Name: visibleName, Description: t.Description, InputSchema: t.InputSchema})
+	}
+	return visible, aliasToCanonical
+}
+
+func matchesAny(patterns []*regexp.Regexp, name string) bool {
+	for _, re := range patterns {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Registry stores named ToolGroups and resolves them against a live set
+// of MCP tools at request time, so the HTTP layer can pick a group per
+// request (see ResolveGroupName) instead of always advertising every
+// registered tool.
+type Registry struct {
+	mu     sync.RWMutex
+	tools  []McpTool
+	groups map[string]ToolGroup
+}
+
+// NewRegistry creates a Registry seeded with the full set of registered
+// MCP tools.
+func NewRegistry(tools []McpTool) *Registry {
+	return &Registry{tools: tools, groups: make(map[string]ToolGroup)}
+}
+
+// SetTools replaces the full set of registered MCP tools that groups
+// resolve against.
+func (r *Registry) SetTools(tools []McpTool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tools = tools
+}
+
+// RegisterGroup adds or replaces a ToolGroup by name.
+func (r *Registry) RegisterGroup(group ToolGroup) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.groups[group.Name] = group
+}
+
+// resolvedGroup returns the visible tools and alias map for groupName. An
+// unknown or empty groupName resolves to every registered mcp__* tool
+// with no aliasing.
+func (r *Registry) resolvedGroup(groupName string) (visible []McpTool, aliasToCanonical map[string]string) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	group, ok := r.groups[groupName]
+	if !ok {
+		aliasToCanonical = make(map[string]string)
+		for _, t := range r.tools {
+			if IsMcpToolName(t.Name) {
+				visible = append(visible, t)
+				aliasToCanonical[t.Name] = t.Name
+			}
+		}
+		return visible, aliasToCanonical
+	}
+	return group.resolve(r.tools)
+}
+
+// PromptFor returns the system prompt fragment(s), across every active
+// encoding (see SelectedEncoding), for groupName's resolved and aliased
+// tool set.
+func (r *Registry) PromptFor(groupName string) string {
+	visible, _ := r.resolvedGroup(groupName)
+	if len(visible) == 0 {
+		return ""
+	}
+
+	var fragments []string
+	for _, enc := range SelectedEncoding() {
+		if frag := enc.SystemPromptFragment(visible); frag != "" {
+			fragments = append(fragments, frag)
+		}
+	}
+	return strings.Join(fragments, "\n\n")
+}
+
+// ParserFor returns an XmlStreamParser scoped to groupName's resolved
+// tool set. An incoming tag using a group alias (e.g. "read_file") is
+// transparently mapped back to its canonical mcp__* name before dispatch.
+func (r *Registry) ParserFor(groupName string) *XmlStreamParser {
+	visible, aliasToCanonical := r.resolvedGroup(groupName)
+
+	names := make([]string, 0, len(visible))
+	for _, t := range visible {
+		names = append(names, t.Name)
+	}
+
+	parser := NewXmlStreamParserWithOptions(names, ParserOptions{Encodings: SelectedEncoding()})
+	parser.aliasToCanonical = aliasToCanonical
+	return parser
+}
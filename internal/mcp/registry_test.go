@@ -0,0 +1,57 @@
+package mcp
+
+import "testing"
+
+// TestToolGroupResolveRejectsAliasCollision verifies that when two
+// canonical tools are aliased to the same visible name, only the first is
+// kept — the second must be dropped entirely rather than silently
+// overwriting the first in aliasToCanonical, which would route calls made
+// through the shared alias to the wrong canonical tool.
+func TestToolGroupResolveRejectsAliasCollision(t *testing.T) {
+	tools := []McpTool{
+		{Name: "mcp__fs__read"},
+		{Name: "mcp__net__read"},
+	}
+	group := ToolGroup{
+		Aliases: map[string]string{
+			"mcp__fs__read":  "read",
+			"mcp__net__read": "read",
+		},
+	}
+
+	visible, aliasToCanonical := group.resolve(tools)
+
+	if len(visible) != 1 {
+		t.Fatalf("visible = %+v, want exactly one tool to survive the collision", visible)
+	}
+	if visible[0].Name != "read" {
+		t.Fatalf("visible[0].Name = %q, want %q", visible[0].Name, "read")
+	}
+	if aliasToCanonical["read"] != "mcp__fs__read" {
+		t.Errorf("aliasToCanonical[\"read\"] = %q, want %q (first claim should win)", aliasToCanonical["read"], "mcp__fs__read")
+	}
+}
+
+// TestToolGroupResolveNoCollision confirms distinct aliases still both
+// resolve normally.
+func TestToolGroupResolveNoCollision(t *testing.T) {
+	tools := []McpTool{
+		{Name: "mcp__fs__read"},
+		{Name: "mcp__fs__write"},
+	}
+	group := ToolGroup{
+		Aliases: map[string]string{
+			"mcp__fs__read":  "read_file",
+			"mcp__fs__write": "write_file",
+		},
+	}
+
+	visible, aliasToCanonical := group.resolve(tools)
+
+	if len(visible) != 2 {
+		t.Fatalf("visible = %+v, want both tools", visible)
+	}
+	if aliasToCanonical["read_file"] != "mcp__fs__read" || aliasToCanonical["write_file"] != "mcp__fs__write" {
+		t.Errorf("aliasToCanonical = %+v, unexpected mapping", aliasToCanonical)
+	}
+}
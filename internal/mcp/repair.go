@@ -0,0 +1,211 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// RepairOptions controls how lenient TryParseMcpToolCallXmlRepair is when
+// the model's output doesn't quite match the expected XML/JSON shape.
+type RepairOptions struct {
+	// StripCodeFences removes a leading/trailing ``` or ```xml/```json
+	// fence wrapped around the tag.
+	StripCodeFences bool
+	// DecodeHtmlEntities decodes &quot; &apos; &lt; &gt; &amp; before
+	// attempting to parse the inner content as JSON.
+	DecodeHtmlEntities bool
+	// TolerateLooseJson pre-processes the inner content into strict JSON
+	// by dropping trailing commas and converting single-quoted keys and
+	// strings to double-quoted ones.
+	TolerateLooseJson bool
+	// AllowAttributeForm accepts a bare key="value" XML-attribute body
+	// (e.g. <mcp__x foo="bar" n="1"/>) as an alternative to a JSON body.
+	AllowAttributeForm bool
+}
+
+// DefaultRepairOptions returns a RepairOptions with every repair enabled.
+func DefaultRepairOptions() RepairOptions {
+	return RepairOptions{
+		StripCodeFences:    true,
+		DecodeHtmlEntities: true,
+		TolerateLooseJson:  true,
+		AllowAttributeForm: true,
+	}
+}
+
+// ParseError describes why TryParseMcpToolCallXmlRepair gave up, in a
+// form the caller can surface back to the model via
+// BuildMcpToolResultXml(toolName, toolUseID, err.Error(), true) so it can
+// self-correct on the next turn.
+type ParseError struct {
+	ToolName string
+	Message  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("failed to parse call to %s: %s", e.ToolName, e.Message)
+}
+
+var (
+	codeFenceRe     = regexp.MustCompile("(?s)^```[a-zA-Z]*\\s*(.*?)\\s*```$")
+	trailingCommaRe = regexp.MustCompile(`,(\s*[}\]])`)
+	singleQuotedRe  = regexp.MustCompile(`'([^'\\]*(?:\\.[^'\\]*)*)'`)
+	attributeRe     = regexp.MustCompile(`([a-zA-Z_][\w-]*)\s*=\s*"([^"]*)"`)
+)
+
+// TryParseMcpToolCallXmlRepair behaves like TryParseMcpToolCallXml but
+// tolerates common malformed model output: markdown code fences around
+// the tag, HTML-entity escaped quotes, trailing commas or single-quoted
+// JSON, and a bare key=value XML-attribute body. On total failure it
+// returns a *ParseError describing the problem instead of just false.
+func TryParseMcpToolCallXmlRepair(xmlText, toolName string, opts RepairOptions) (*McpToolCall, *ParseError) {
+	if toolName == "" || xmlText == "" {
+		return nil, &ParseError{ToolName: toolName, Message: "empty input"}
+	}
+
+	text := strings.TrimSpace(xmlText)
+	if opts.StripCodeFences {
+		text = stripCodeFence(text)
+	}
+
+	openPattern := regexp.MustCompile(`^\s*<` + regexp.QuoteMeta(toolName) + `(\s[^>]*)?/?>`)
+	closePattern := regexp.MustCompile(`</` + regexp.QuoteMeta(toolName) + `\s*>\s*$`)
+
+	openMatch := openPattern.FindStringIndex(text)
+	if openMatch == nil {
+		return nil, &ParseError{ToolName: toolName, Message: "missing opening tag"}
+	}
+	openTag := text[openMatch[0]:openMatch[1]]
+
+	id := MakeToolUseID(toolName)
+	if explicit, ok := extractTagID(openTag); ok && explicit != "" {
+		id = explicit
+	}
+
+	var inner string
+	if strings.HasSuffix(strings.TrimSpace(openTag), "/>") {
+		inner = ""
+	} else {
+		closeMatch := closePattern.FindStringIndex(text)
+		if closeMatch == nil {
+			return nil, &ParseError{ToolName: toolName, Message: "missing closing tag"}
+		}
+		inner = strings.TrimSpace(text[openMatch[1]:closeMatch[0]])
+		if opts.StripCodeFences {
+			inner = stripCodeFence(inner)
+		}
+	}
+
+	if inner == "" {
+		if opts.AllowAttributeForm {
+			if attrs, ok := parseAttributeForm(openTag); ok {
+				return &McpToolCall{Name: toolName, Input: attrs, ID: id}, nil
+			}
+		}
+		return &McpToolCall{Name: toolName, Input: make(map[string]interface{}), ID: id}, nil
+	}
+
+	candidate := inner
+	if opts.DecodeHtmlEntities {
+		candidate = decodeHtmlEntities(candidate)
+	}
+
+	// Try the candidate as-is first: toStrictJson's single-quote rewrite
+	// can't tell a loose single-quoted string from an apostrophe inside an
+	// already-valid double-quoted JSON string, so only fall back to it
+	// once a direct parse has actually failed.
+	var parsed map[string]interface{}
+	err := json.Unmarshal([]byte(candidate), &parsed)
+	if err != nil && opts.TolerateLooseJson {
+		loosened := toStrictJson(candidate)
+		if loosenErr := json.Unmarshal([]byte(loosened), &parsed); loosenErr == nil {
+			err = nil
+		}
+	}
+	if err != nil {
+		if opts.AllowAttributeForm {
+			if attrs, ok := parseAttributeForm(openTag); ok {
+				return &McpToolCall{Name: toolName, Input: attrs, ID: id}, nil
+			}
+		}
+		return nil, &ParseError{ToolName: toolName, Message: "invalid JSON body: " + err.Error()}
+	}
+
+	return &McpToolCall{Name: toolName, Input: parsed, ID: id}, nil
+}
+
+// stripCodeFence removes a leading/trailing ``` or ```xml/```json fence,
+// if one wraps the whole string.
+func stripCodeFence(s string) string {
+	if m := codeFenceRe.FindStringSubmatch(s); m != nil {
+		return strings.TrimSpace(m[1])
+	}
+	return s
+}
+
+// decodeHtmlEntities decodes the handful of entities a model is likely to
+// emit when it escapes XML-sensitive characters inside a JSON body.
+func decodeHtmlEntities(s string) string {
+	replacer := strings.NewReplacer(
+		"&quot;", `"`,
+		"&apos;", "'",
+		"&lt;", "<",
+		"&gt;", ">",
+		"&amp;", "&",
+	)
+	return replacer.Replace(s)
+}
+
+// toStrictJson drops trailing commas and converts single-quoted keys and
+// strings to double-quoted ones, so near-miss JSON can be parsed with the
+// standard library decoder.
+func toStrictJson(s string) string {
+	s = singleQuotedRe.ReplaceAllStringFunc(s, func(m string) string {
+		inner := m[1 : len(m)-1]
+		inner = strings.ReplaceAll(inner, `"`, `\"`)
+		return `"` + inner + `"`
+	})
+	s = trailingCommaRe.ReplaceAllString(s, "$1")
+	return s
+}
+
+// parseAttributeForm reads a bare key="value" XML-attribute body out of
+// an opening tag, e.g. <mcp__x foo="bar" n="1"/>. The "id" attribute is
+// reserved for the upstream tool_use_id (see extractTagID) and is never
+// treated as part of the tool's input.
+func parseAttributeForm(openTag string) (map[string]interface{}, bool) {
+	matches := attributeRe.FindAllStringSubmatch(openTag, -1)
+	if len(matches) == 0 {
+		return nil, false
+	}
+	result := make(map[string]interface{}, len(matches))
+	for _, m := range matches {
+		if m[1] == "id" {
+			continue
+		}
+		result[m[1]] = coerceAttributeValue(m[2])
+	}
+	if len(result) == 0 {
+		return nil, false
+	}
+	return result, true
+}
+
+// coerceAttributeValue guesses a JSON-ish type for a bare attribute value
+// so the resulting Input map looks the same whether the model used the
+// JSON body form or the attribute form.
+func coerceAttributeValue(raw string) interface{} {
+	switch raw {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if n, err := strconv.ParseFloat(raw, 64); err == nil {
+		return n
+	}
+	return raw
+}
@@ -0,0 +1,65 @@
+package mcp
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestToStrictJsonDoesNotCorruptValidJsonWithApostrophes verifies that a
+// well-formed JSON body containing an apostrophe inside a double-quoted
+// string parses as-is instead of being mangled by the single-quote
+// loosening pass, since that pass only runs now as a fallback after a
+// direct parse attempt fails.
+func TestToStrictJsonDoesNotCorruptValidJsonWithApostrophes(t *testing.T) {
+	xml := `<mcp__fs__write>{"content": "it's a test's sample", "path": "a.txt"}</mcp__fs__write>`
+	call, parseErr := TryParseMcpToolCallXmlRepair(xml, "mcp__fs__write", DefaultRepairOptions())
+	if parseErr != nil {
+		t.Fatalf("TryParseMcpToolCallXmlRepair failed: %v", parseErr)
+	}
+	if call.Input["content"] != "it's a test's sample" {
+		t.Errorf("Input[\"content\"] = %v, want %q", call.Input["content"], "it's a test's sample")
+	}
+}
+
+// TestToStrictJsonRepairsLooseSingleQuotedJson confirms the single-quote
+// loosening fallback still kicks in for genuinely loose JSON.
+func TestToStrictJsonRepairsLooseSingleQuotedJson(t *testing.T) {
+	xml := `<mcp__fs__write>{'content': 'hello', 'path': 'a.txt',}</mcp__fs__write>`
+	call, parseErr := TryParseMcpToolCallXmlRepair(xml, "mcp__fs__write", DefaultRepairOptions())
+	if parseErr != nil {
+		t.Fatalf("TryParseMcpToolCallXmlRepair failed: %v", parseErr)
+	}
+	if call.Input["content"] != "hello" {
+		t.Errorf("Input[\"content\"] = %v, want %q", call.Input["content"], "hello")
+	}
+}
+
+// TestPushTextFallsBackToRepairParser checks that a malformed-but-recoverable
+// tool call (single-quoted JSON) streamed through XmlStreamParser.PushText
+// is repaired into a "tool" result instead of being dropped as text.
+func TestPushTextFallsBackToRepairParser(t *testing.T) {
+	p := NewXmlStreamParserWithOptions([]string{"mcp__fs__write"}, ParserOptions{Buffered: true})
+	results := p.PushText(`<mcp__fs__write>{'path': 'a.txt'}</mcp__fs__write>`)
+
+	if len(results) != 1 || results[0].Type != "tool" {
+		t.Fatalf("results = %+v, want a single tool result", results)
+	}
+	if results[0].Input["path"] != "a.txt" {
+		t.Errorf("Input[\"path\"] = %v, want %q", results[0].Input["path"], "a.txt")
+	}
+}
+
+// TestPushTextReportsUnrepairableCallAsError checks that a call the repair
+// parser still can't make sense of comes back as an is_error tool result
+// (so the model can self-correct) instead of vanishing silently.
+func TestPushTextReportsUnrepairableCallAsError(t *testing.T) {
+	p := NewXmlStreamParserWithOptions([]string{"mcp__fs__write"}, ParserOptions{Buffered: true})
+	results := p.PushText(`<mcp__fs__write>{not json at all</mcp__fs__write>`)
+
+	if len(results) != 1 || results[0].Type != "text" {
+		t.Fatalf("results = %+v, want a single text result", results)
+	}
+	if !strings.Contains(results[0].Text, `"is_error":true`) {
+		t.Errorf("Text = %q, want it to contain an is_error tool result", results[0].Text)
+	}
+}
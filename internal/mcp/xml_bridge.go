@@ -7,27 +7,27 @@ package mcp
 import (
 	"encoding/json"
 	"fmt"
-	"os"
 	"regexp"
 	"strings"
 	"sync/atomic"
+	"unicode/utf8"
 )
 
-// MCP_XML_ENV is the environment variable name to control MCP XML mode.
-// Set to "0", "false", "no", or "off" to disable. Enabled by default.
-const MCP_XML_ENV = "AG2API_MCP_XML_ENABLED"
-
 var mcpToolUseIDCounter uint64
 
-// IsMcpXmlEnabled checks if MCP XML mode is enabled.
-// Returns true by default. Set AG2API_MCP_XML_ENABLED=false to disable.
-func IsMcpXmlEnabled() bool {
-	raw := os.Getenv(MCP_XML_ENV)
-	if raw == "" {
-		return true // 默认启用
+var idAttrRe = regexp.MustCompile(`\bid\s*=\s*"([^"]*)"`)
+
+// extractTagID reads an optional id="..." attribute out of an opening
+// tag, e.g. <mcp__server__tool id="toolu_123">, so a rewritten/re-parsed
+// tool call keeps the ID assigned by whoever generated it (typically the
+// upstream provider via Bridge.RewriteNativeToolUse) instead of minting a
+// brand-new one.
+func extractTagID(openTag string) (string, bool) {
+	m := idAttrRe.FindStringSubmatch(openTag)
+	if m == nil {
+		return "", false
 	}
-	v := strings.ToLower(strings.TrimSpace(raw))
-	return v != "0" && v != "false" && v != "no" && v != "off"
+	return m[1], true
 }
 
 // IsMcpToolName checks if a tool name is an MCP tool (prefixed with "mcp__").
@@ -46,48 +46,22 @@ func GetMcpToolNames(tools []string) []string {
 	return result
 }
 
-// BuildMcpXmlSystemPrompt generates a system prompt instructing the model
-// to use XML format for MCP tool calls instead of normal function calling.
+// BuildMcpXmlSystemPrompt concatenates the system prompt fragments of
+// every encoding returned by SelectedEncoding, so a single system prompt
+// teaches the model every active way (XML, fenced JSON, YAML, ...) to
+// call MCP tools.
 func BuildMcpXmlSystemPrompt(mcpTools []McpTool) string {
 	if len(mcpTools) == 0 {
 		return ""
 	}
 
-	var lines []string
-	lines = append(lines, "==== MCP XML 工具调用（仅 mcp__*） ====")
-	lines = append(lines, "当你需要调用名称以 `mcp__` 开头的 MCP 工具时：")
-	lines = append(lines, "1) 不要使用 tool_use/function_call（因为该链路会报错）。")
-	lines = append(lines, "2) 直接输出一个 XML 块（只输出 XML，不要解释/不要 markdown）。")
-	lines = append(lines, "3) XML 的根标签必须是工具名，内容必须是 JSON（对象/数组），表示该工具的入参。")
-	lines = append(lines, "")
-	lines = append(lines, "示例：")
-	lines = append(lines, `<mcp__server__tool>{"arg":"value"}</mcp__server__tool>`)
-	lines = append(lines, "")
-	lines = append(lines, "工具执行完成后，我会把结果以如下 XML 返回给你：")
-	lines = append(lines, `<mcp_tool_result>{"name":"mcp__server__tool","tool_use_id":"toolu_xxx","result":"...","is_error":false}</mcp_tool_result>`)
-	lines = append(lines, "")
-	lines = append(lines, "当 is_error 为 true 时，表示该工具执行失败，result 内容为错误信息。")
-	lines = append(lines, "")
-	lines = append(lines, "对于非 `mcp__*` 工具：继续使用正常的工具调用机制。")
-	lines = append(lines, "")
-	lines = append(lines, "可用 MCP 工具列表（name / description / input_schema）：")
-
-	for _, tool := range mcpTools {
-		if !IsMcpToolName(tool.Name) {
-			continue
-		}
-		desc := tool.Description
-		line := fmt.Sprintf("- %s", tool.Name)
-		if desc != "" {
-			line += ": " + desc
-		}
-		lines = append(lines, line)
-		if tool.InputSchema != "" {
-			lines = append(lines, "  input_schema: "+tool.InputSchema)
+	var fragments []string
+	for _, enc := range SelectedEncoding() {
+		if frag := enc.SystemPromptFragment(mcpTools); frag != "" {
+			fragments = append(fragments, frag)
 		}
 	}
-
-	return strings.Join(lines, "\n")
+	return strings.Join(fragments, "\n\n")
 }
 
 // McpTool represents an MCP tool definition.
@@ -121,32 +95,162 @@ func MakeToolUseID(name string) string {
 	return fmt.Sprintf("%s-%d", name, atomic.AddUint64(&mcpToolUseIDCounter, 1))
 }
 
-// XmlStreamParser parses streaming text for MCP XML tool calls.
+// XmlStreamParser parses streaming text for MCP tool calls.
 type XmlStreamParser struct {
-	toolNames map[string]bool
-	buffer    string
+	toolNames    map[string]bool
+	buffer       string
+	buffered     bool              // when true, disables tool_delta streaming
+	active       *activeToolStream // set while collecting an in-progress tool call
+	pendingCalls []McpToolCall     // calls parsed since the last FlushTurn
+
+	// encodings is the set of CallEncoding(s) this parser recognizes.
+	// xmlEncoding, if present, keeps the incremental tool_delta streaming
+	// path below; any other (fenced) encoding is only ever emitted as a
+	// single "tool" result once its whole fence has arrived, since a
+	// fenced block is small and typically emitted in one burst rather
+	// than token-by-token like free-form XML content.
+	encodings []CallEncoding
+
+	// aliasToCanonical maps a tag name seen in the stream (e.g. a
+	// Registry group alias like "read_file") back to the canonical
+	// mcp__* tool name surfaced in ParseResult/McpToolCall. Set by
+	// Registry.ParserFor; nil means tag names are already canonical.
+	aliasToCanonical map[string]string
+}
+
+// canonicalName translates an alias tag name back to its canonical
+// mcp__* name, or returns name unchanged if there is no alias mapping.
+func (p *XmlStreamParser) canonicalName(name string) string {
+	if canonical, ok := p.aliasToCanonical[name]; ok {
+		return canonical
+	}
+	return name
 }
 
-// NewXmlStreamParser creates a new parser for the given MCP tool names.
+// activeToolStream tracks the incremental JSON tokenizer state for the
+// tool call currently being collected, so PushText can emit safe
+// tool_delta chunks without waiting for the closing tag.
+type activeToolStream struct {
+	name     string
+	id       string
+	scanned  int // bytes of buffer already scanned by the tokenizer
+	emitted  int // bytes of buffer already emitted as a delta
+	inString bool
+	escaped  bool
+}
+
+// ParserOptions configures an XmlStreamParser.
+type ParserOptions struct {
+	// Buffered disables incremental tool_delta streaming and restores the
+	// original behavior of only emitting a "tool" result once the full
+	// closing tag has arrived.
+	Buffered bool
+	// Encodings selects which CallEncoding(s) PushText recognizes in the
+	// stream. Empty defaults to []CallEncoding{xmlEncoding{}}, preserving
+	// NewXmlStreamParser's original XML-only behavior. Pass
+	// SelectedEncoding() to honor AG2API_MCP_ENCODING.
+	Encodings []CallEncoding
+}
+
+// NewXmlStreamParser creates a new parser for the given MCP tool names
+// with incremental tool_delta streaming enabled, recognizing only the XML
+// call syntax. Use NewXmlStreamParserWithOptions with ParserOptions.Encodings
+// set to SelectedEncoding() to also recognize fenced JSON/YAML calls.
 func NewXmlStreamParser(toolNames []string) *XmlStreamParser {
+	return NewXmlStreamParserWithOptions(toolNames, ParserOptions{})
+}
+
+// NewXmlStreamParserWithOptions creates a new parser for the given MCP
+// tool names. Set opts.Buffered to true to fall back to the pre-streaming
+// behavior of waiting for the full closing tag before emitting anything.
+func NewXmlStreamParserWithOptions(toolNames []string, opts ParserOptions) *XmlStreamParser {
 	names := make(map[string]bool)
 	for _, name := range toolNames {
 		if name != "" {
 			names[name] = true
 		}
 	}
+	encodings := opts.Encodings
+	if len(encodings) == 0 {
+		encodings = []CallEncoding{xmlEncoding{}}
+	}
 	return &XmlStreamParser{
 		toolNames: names,
 		buffer:    "",
+		buffered:  opts.Buffered,
+		encodings: encodings,
+	}
+}
+
+// streamableEncoding is an optional CallEncoding capability: the ability
+// to report that a call's opening marker has fully arrived in the
+// buffer, so PushText can start emitting tool_delta chunks for it right
+// away instead of waiting for ScanAny to find the whole call. Only
+// xmlEncoding implements it today; any CallEncoding that doesn't is
+// still fully recognized by PushText via the generic ScanAny dispatch
+// below — it is just emitted as one complete "tool" result instead of
+// incremental deltas.
+type streamableEncoding interface {
+	scanOpenTag(buffer string, toolNames map[string]bool) (start, tagEnd int, name, id string, ok bool)
+}
+
+// recoverableEncoding is an optional CallEncoding capability: a tolerant,
+// best-effort second pass for a call whose start/end markers are both
+// present in the buffer but whose body didn't parse cleanly via Scan.
+// Only xmlEncoding implements it today, via TryParseMcpToolCallXmlRepair.
+// Any CallEncoding that doesn't implement it is simply left to ScanAny; a
+// genuinely malformed call of that encoding falls through as plain text.
+type recoverableEncoding interface {
+	recoverFailedCall(buffer string, toolNames map[string]bool) (matchStart, matchEnd int, call *McpToolCall, parseErr *ParseError, ok bool)
+}
+
+// scanStreamableOpen returns the first streamableEncoding match among
+// p.encodings, if any.
+func (p *XmlStreamParser) scanStreamableOpen() (start, tagEnd int, name, id string, ok bool) {
+	for _, enc := range p.encodings {
+		if se, isStreamable := enc.(streamableEncoding); isStreamable {
+			if s, te, n, i, found := se.scanOpenTag(p.buffer, p.toolNames); found {
+				return s, te, n, i, true
+			}
+		}
+	}
+	return 0, 0, "", "", false
+}
+
+// recoverFailedCall returns the first recoverableEncoding match among
+// p.encodings, if any.
+func (p *XmlStreamParser) recoverFailedCall() (matchStart, matchEnd int, call *McpToolCall, parseErr *ParseError, ok bool) {
+	for _, enc := range p.encodings {
+		if rec, isRecoverable := enc.(recoverableEncoding); isRecoverable {
+			if ms, me, c, pe, found := rec.recoverFailedCall(p.buffer, p.toolNames); found {
+				return ms, me, c, pe, true
+			}
+		}
 	}
+	return 0, 0, nil, nil, false
+}
+
+// fencedEncodings returns this parser's active encodings that wrap a call
+// in a pair of code-fence markers, for the partial-tail holdback check in
+// isPossibleToolTagPrefix.
+func (p *XmlStreamParser) fencedEncodings() []CallEncoding {
+	var out []CallEncoding
+	for _, e := range p.encodings {
+		if _, ok := e.(fencedEncoding); ok {
+			out = append(out, e)
+		}
+	}
+	return out
 }
 
 // ParseResult represents a parsed chunk from the stream.
 type ParseResult struct {
-	Type  string                 // "text" or "tool"
-	Text  string                 // For type "text"
-	Name  string                 // For type "tool"
-	Input map[string]interface{} // For type "tool"
+	Type        string                 // "text", "tool_delta", or "tool"
+	Text        string                 // For type "text"
+	Name        string                 // For type "tool_delta" and "tool"
+	ID          string                 // For type "tool_delta" and "tool"; shared across all deltas of one call
+	PartialJSON string                 // For type "tool_delta": the newly arrived, safe-to-append slice of the JSON input
+	Input       map[string]interface{} // For type "tool": the fully-parsed input
 }
 
 // PushText adds text to the parser and returns any complete results.
@@ -158,51 +262,208 @@ func (p *XmlStreamParser) PushText(text string) []ParseResult {
 	p.buffer += text
 
 	for {
-		index, name := p.findNextToolStartIndex()
-		if index == -1 || name == "" {
-			// Check for partial tag at end
-			emit, keep := p.splitBufferForPartialTag()
-			if emit != "" {
-				results = append(results, ParseResult{Type: "text", Text: emit})
+		if p.active != nil {
+			results = append(results, p.advanceActive()...)
+			if p.active != nil {
+				break // still waiting for more data
 			}
-			p.buffer = keep
-			break
+			continue
 		}
 
-		// Emit text before the tool tag
-		if index > 0 {
-			results = append(results, ParseResult{Type: "text", Text: p.buffer[:index]})
-			p.buffer = p.buffer[index:]
+		// Every active encoding gets a say in what the next call is, via
+		// three independent views of the buffer:
+		//   - ScanAny: the generic "find a complete call" path every
+		//     CallEncoding supports, run concurrently across all of them.
+		//   - scanStreamableOpen: an encoding may optionally start emitting
+		//     tool_delta chunks as soon as just its open marker has arrived
+		//     (xmlEncoding only, today), rather than waiting for ScanAny to
+		//     see the whole call.
+		//   - recoverFailedCall: an encoding may optionally offer a
+		//     tolerant second pass for a call whose markers are both
+		//     present but whose body didn't parse cleanly via Scan
+		//     (xmlEncoding only, today, via the repair parser).
+		// Whichever candidate starts earliest in the buffer wins; ties
+		// favor streaming, then a clean Scan, over recovery.
+		scanStart, scanEnd, scanCall, scanOK := func() (int, int, *McpToolCall, bool) {
+			s, e, c, _, ok := ScanAny(p.encodings, p.buffer, p.toolNames)
+			return s, e, c, ok
+		}()
+
+		streamStart, streamTagEnd, streamName, streamID, streamOK := -1, -1, "", "", false
+		if !p.buffered {
+			streamStart, streamTagEnd, streamName, streamID, streamOK = p.scanStreamableOpen()
 		}
 
-		// Try to find complete tool call
-		closeEnd := p.findCloseTagEndIndex(name)
-		if closeEnd == -1 {
-			break // Incomplete, wait for more data
+		recStart, recEnd, recCall, recErr, recOK := p.recoverFailedCall()
+
+		const (
+			candNone = iota
+			candStream
+			candScan
+			candRecover
+		)
+		winner, winnerIdx := candNone, -1
+		if streamOK {
+			winner, winnerIdx = candStream, streamStart
+		}
+		if scanOK && (winner == candNone || scanStart < winnerIdx) {
+			winner, winnerIdx = candScan, scanStart
+		}
+		if recOK && (winner == candNone || recStart < winnerIdx) {
+			winner, winnerIdx = candRecover, recStart
 		}
 
-		xml := p.buffer[:closeEnd]
-		p.buffer = p.buffer[closeEnd:]
+		switch winner {
+		case candStream:
+			if streamStart > 0 {
+				results = append(results, ParseResult{Type: "text", Text: p.buffer[:streamStart]})
+			}
+			p.buffer = p.buffer[streamTagEnd:]
+			p.active = &activeToolStream{name: streamName, id: streamID}
+			continue
 
-		// Parse the XML
-		parsed, ok := TryParseMcpToolCallXml(xml, name)
-		if ok {
+		case candScan:
+			if scanStart > 0 {
+				results = append(results, ParseResult{Type: "text", Text: p.buffer[:scanStart]})
+			}
+			p.buffer = p.buffer[scanEnd:]
 			results = append(results, ParseResult{
 				Type:  "tool",
-				Name:  parsed.Name,
-				Input: parsed.Input,
+				Name:  p.canonicalName(scanCall.Name),
+				ID:    scanCall.ID,
+				Input: scanCall.Input,
 			})
-		} else {
-			results = append(results, ParseResult{Type: "text", Text: xml})
+			continue
+
+		case candRecover:
+			if recStart > 0 {
+				results = append(results, ParseResult{Type: "text", Text: p.buffer[:recStart]})
+			}
+			p.buffer = p.buffer[recEnd:]
+			if recErr != nil {
+				results = append(results, ParseResult{Type: "text", Text: BuildMcpToolResultXml(recErr.ToolName, MakeToolUseID(recErr.ToolName), recErr.Error(), true)})
+			} else {
+				results = append(results, ParseResult{
+					Type:  "tool",
+					Name:  p.canonicalName(recCall.Name),
+					ID:    recCall.ID,
+					Input: recCall.Input,
+				})
+			}
+			continue
+
+		default:
+			// Nothing recognized yet; hold back a possible partial
+			// tag/fence at the tail and emit the rest as plain text.
+			emit, keep := p.splitBufferForPartialTag()
+			if emit != "" {
+				results = append(results, ParseResult{Type: "text", Text: emit})
+			}
+			p.buffer = keep
+		}
+		break
+	}
+
+	for _, r := range results {
+		if r.Type == "tool" {
+			p.pendingCalls = append(p.pendingCalls, McpToolCall{Name: r.Name, Input: r.Input, ID: r.ID})
 		}
 	}
 
 	return results
 }
 
+// advanceActive processes buffered bytes for the in-progress tool call,
+// either finalizing it (closing tag found) or emitting an incremental
+// tool_delta for the safe-to-emit portion of the newly arrived bytes.
+func (p *XmlStreamParser) advanceActive() []ParseResult {
+	a := p.active
+
+	closeEnd := p.findCloseTagEndIndex(a.name)
+	if closeEnd != -1 {
+		closeStart := strings.Index(p.buffer[:closeEnd], "</"+a.name)
+		inner := strings.TrimSpace(p.buffer[:closeStart])
+		p.buffer = p.buffer[closeEnd:]
+		p.active = nil
+
+		input := make(map[string]interface{})
+		if inner != "" {
+			if err := json.Unmarshal([]byte(inner), &input); err != nil {
+				// Malformed JSON despite already-streamed deltas: try the
+				// tolerant repair parser before giving up, then report the
+				// failure back as an is_error tool result so the model can
+				// self-correct instead of silently losing the call.
+				fullXml := "<" + a.name + ">" + inner + "</" + a.name + ">"
+				if repaired, parseErr := TryParseMcpToolCallXmlRepair(fullXml, a.name, DefaultRepairOptions()); parseErr == nil {
+					return []ParseResult{{Type: "tool", Name: p.canonicalName(a.name), ID: a.id, Input: repaired.Input}}
+				} else {
+					return []ParseResult{{Type: "text", Text: BuildMcpToolResultXml(a.name, a.id, parseErr.Error(), true)}}
+				}
+			}
+		}
+		return []ParseResult{{Type: "tool", Name: p.canonicalName(a.name), ID: a.id, Input: input}}
+	}
+
+	// No closing tag yet. Scan only up to whatever can't be the start of
+	// the closing tag, tracking string/escape state so we never cut mid
+	// escape sequence, then trim back further if that lands inside an
+	// incomplete UTF-8 rune.
+	safeEnd := len(p.buffer) - partialCloseTagSuffixLen(p.buffer, a.name)
+	for a.scanned < safeEnd {
+		b := p.buffer[a.scanned]
+		switch {
+		case a.escaped:
+			a.escaped = false
+		case b == '\\':
+			a.escaped = true
+		case b == '"':
+			a.inString = !a.inString
+		}
+		a.scanned++
+	}
+
+	cut := a.scanned
+	if a.escaped {
+		cut-- // hold back a trailing backslash until its escaped char arrives
+	}
+	cut = trimIncompleteUTF8Cut(p.buffer, cut)
+	if cut <= a.emitted {
+		return nil
+	}
+
+	delta := p.buffer[a.emitted:cut]
+	a.emitted = cut
+	return []ParseResult{{Type: "tool_delta", Name: p.canonicalName(a.name), ID: a.id, PartialJSON: delta}}
+}
+
+// ToolBatch is the set of MCP tool calls parsed since the last FlushTurn,
+// i.e. one "turn" of (possibly parallel) calls a runtime can dispatch
+// together.
+type ToolBatch struct {
+	Calls []McpToolCall
+}
+
+// FlushTurn returns a ToolBatch of every tool call parsed since the
+// previous FlushTurn (or since the parser was created) and clears the
+// pending list. Call it once the assistant's message boundary is reached
+// so the runtime can dispatch the whole turn's calls concurrently.
+func (p *XmlStreamParser) FlushTurn() ToolBatch {
+	batch := ToolBatch{Calls: p.pendingCalls}
+	p.pendingCalls = nil
+	return batch
+}
+
 // Flush returns any remaining buffered content.
 func (p *XmlStreamParser) Flush() []ParseResult {
 	var results []ParseResult
+	if p.active != nil {
+		if p.buffer != "" {
+			results = append(results, ParseResult{Type: "text", Text: "<" + p.active.name + ">" + p.buffer})
+		}
+		p.active = nil
+		p.buffer = ""
+		return results
+	}
 	if p.buffer != "" {
 		results = append(results, ParseResult{Type: "text", Text: p.buffer})
 		p.buffer = ""
@@ -210,42 +471,25 @@ func (p *XmlStreamParser) Flush() []ParseResult {
 	return results
 }
 
-func (p *XmlStreamParser) findNextToolStartIndex() (int, string) {
-	best := -1
-	bestName := ""
-	for name := range p.toolNames {
-		open := "<" + name
-		idx := strings.Index(p.buffer, open)
-		if idx == -1 {
-			continue
-		}
-		// Check boundary character
-		if idx+len(open) < len(p.buffer) {
-			ch := p.buffer[idx+len(open)]
-			if ch != '>' && ch != '/' && ch != ' ' && ch != '\t' && ch != '\n' && ch != '\r' {
-				continue
-			}
-		}
-		if best == -1 || idx < best {
-			best = idx
-			bestName = name
-		}
-	}
-	return best, bestName
+func (p *XmlStreamParser) findCloseTagEndIndex(name string) int {
+	return closeTagEndIndex(p.buffer, name)
 }
 
-func (p *XmlStreamParser) findCloseTagEndIndex(name string) int {
+// closeTagEndIndex returns the index right after the closing '>' of
+// "</"+name in buffer, or -1 if it hasn't fully arrived yet. Shared by
+// XmlStreamParser.advanceActive and xmlEncoding.recoverFailedCall, which
+// need the same closing-tag lookup over two different buffer views.
+func closeTagEndIndex(buffer, name string) int {
 	needle := "</" + name
-	idx := strings.Index(p.buffer, needle)
+	idx := strings.Index(buffer, needle)
 	if idx == -1 {
 		return -1
 	}
 	after := idx + len(needle)
-	if after >= len(p.buffer) {
+	if after >= len(buffer) {
 		return -1 // Incomplete
 	}
-	// Find the closing >
-	gtIdx := strings.Index(p.buffer[after:], ">")
+	gtIdx := strings.Index(buffer[after:], ">")
 	if gtIdx == -1 {
 		return -1
 	}
@@ -253,34 +497,100 @@ func (p *XmlStreamParser) findCloseTagEndIndex(name string) int {
 }
 
 func (p *XmlStreamParser) splitBufferForPartialTag() (string, string) {
-	lastLt := strings.LastIndex(p.buffer, "<")
-	if lastLt == -1 {
+	// A call whose opening tag or fence has arrived but whose closing
+	// marker hasn't yet is held back whole until the rest of it arrives.
+	hold := -1
+	for _, enc := range p.encodings {
+		start := -1
+		if fe, isFenced := enc.(fencedEncoding); isFenced {
+			if i := strings.LastIndex(p.buffer, fe.fenceOpen()); i != -1 && !strings.Contains(p.buffer[i+len(fe.fenceOpen()):], fe.fenceClose()) {
+				start = i
+			}
+		} else if _, isXml := enc.(xmlEncoding); isXml {
+			start, _ = findFirstOpenTag(p.buffer, p.toolNames)
+		}
+		if start != -1 && (hold == -1 || start < hold) {
+			hold = start
+		}
+	}
+	if hold != -1 {
+		return p.buffer[:hold], p.buffer[hold:]
+	}
+
+	cut := strings.LastIndex(p.buffer, "<")
+	if idx := strings.LastIndex(p.buffer, "`"); idx > cut {
+		cut = idx
+		for cut > 0 && p.buffer[cut-1] == '`' {
+			cut-- // a fence marker starts at the first of its run of backticks
+		}
+	}
+	if cut == -1 {
 		return p.buffer, ""
 	}
-	tail := p.buffer[lastLt:]
+	tail := p.buffer[cut:]
 	if p.isPossibleToolTagPrefix(tail) {
-		return p.buffer[:lastLt], tail
+		return p.buffer[:cut], tail
 	}
 	return p.buffer, ""
 }
 
 func (p *XmlStreamParser) isPossibleToolTagPrefix(text string) bool {
-	if !strings.HasPrefix(text, "<") {
+	if strings.HasPrefix(text, "<") {
+		for name := range p.toolNames {
+			open := "<" + name
+			if strings.HasPrefix(open, text) {
+				return true
+			}
+			close := "</" + name
+			if strings.HasPrefix(close, text) {
+				return true
+			}
+		}
 		return false
 	}
-	for name := range p.toolNames {
-		open := "<" + name
-		if strings.HasPrefix(open, text) {
-			return true
-		}
-		close := "</" + name
-		if strings.HasPrefix(close, text) {
-			return true
+	if strings.HasPrefix(text, "`") {
+		for _, enc := range p.fencedEncodings() {
+			if fe, ok := enc.(fencedEncoding); ok && strings.HasPrefix(fe.fenceOpen(), text) {
+				return true
+			}
 		}
 	}
 	return false
 }
 
+// partialCloseTagSuffixLen returns the length of the longest suffix of
+// buf that is itself a prefix of the closing tag "</"+name, so a caller
+// can avoid treating a not-yet-complete closing tag as tool input.
+func partialCloseTagSuffixLen(buf, name string) int {
+	needle := "</" + name
+	maxLen := len(needle)
+	if maxLen > len(buf) {
+		maxLen = len(buf)
+	}
+	for l := maxLen; l > 0; l-- {
+		if strings.HasSuffix(buf, needle[:l]) {
+			return l
+		}
+	}
+	return 0
+}
+
+// trimIncompleteUTF8Cut walks cut backwards while buf[:cut] ends in a
+// truncated multi-byte UTF-8 sequence, so a delta never splits a rune.
+func trimIncompleteUTF8Cut(buf string, cut int) int {
+	for i := 0; i < utf8.UTFMax && cut > 0; i++ {
+		if buf[cut-1] < utf8.RuneSelf {
+			break // plain ASCII byte, nothing to trim
+		}
+		r, size := utf8.DecodeLastRuneInString(buf[:cut])
+		if r != utf8.RuneError || size > 1 {
+			break // complete rune
+		}
+		cut--
+	}
+	return cut
+}
+
 // TryParseMcpToolCallXml attempts to parse an XML tool call.
 func TryParseMcpToolCallXml(xmlText, toolName string) (*McpToolCall, bool) {
 	if toolName == "" || xmlText == "" {
@@ -298,6 +608,11 @@ func TryParseMcpToolCallXml(xmlText, toolName string) (*McpToolCall, bool) {
 		return nil, false
 	}
 
+	id := MakeToolUseID(toolName)
+	if explicit, ok := extractTagID(xmlText[openMatch[0]:openMatch[1]]); ok && explicit != "" {
+		id = explicit
+	}
+
 	// Extract inner content
 	inner := xmlText[openMatch[1]:closeMatch[0]]
 	inner = strings.TrimSpace(inner)
@@ -306,7 +621,7 @@ func TryParseMcpToolCallXml(xmlText, toolName string) (*McpToolCall, bool) {
 		return &McpToolCall{
 			Name:  toolName,
 			Input: make(map[string]interface{}),
-			ID:    MakeToolUseID(toolName),
+			ID:    id,
 		}, true
 	}
 
@@ -319,6 +634,6 @@ func TryParseMcpToolCallXml(xmlText, toolName string) (*McpToolCall, bool) {
 	return &McpToolCall{
 		Name:  toolName,
 		Input: parsed,
-		ID:    MakeToolUseID(toolName),
+		ID:    id,
 	}, true
 }
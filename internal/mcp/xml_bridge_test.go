@@ -0,0 +1,104 @@
+package mcp
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// TestPushTextStreamsDeltasBeforeClosingTag verifies that tool_delta
+// chunks arrive incrementally as argument bytes are pushed, instead of
+// everything arriving at once only after the closing tag is seen.
+func TestPushTextStreamsDeltasBeforeClosingTag(t *testing.T) {
+	p := NewXmlStreamParser([]string{"mcp__fs__write"})
+
+	first := p.PushText(`<mcp__fs__write>{"path":"a`)
+	if len(first) == 0 {
+		t.Fatalf("got no results before the closing tag arrived, want at least one tool_delta")
+	}
+	for _, r := range first {
+		if r.Type != "tool_delta" {
+			t.Errorf("result type = %q, want tool_delta before the closing tag", r.Type)
+		}
+	}
+
+	second := p.PushText(`.txt"}</mcp__fs__write>`)
+	var gotTool bool
+	for _, r := range second {
+		if r.Type == "tool" {
+			gotTool = true
+			if r.Input["path"] != "a.txt" {
+				t.Errorf("Input[\"path\"] = %v, want %q", r.Input["path"], "a.txt")
+			}
+		}
+	}
+	if !gotTool {
+		t.Fatalf("second push did not produce a final tool result: %+v", second)
+	}
+}
+
+// TestPushTextDeltasConcatenateToFullInput checks that concatenating every
+// tool_delta's PartialJSON, in arrival order, reproduces the full JSON
+// input body (modulo the trailing slice already folded into the final
+// "tool" result), so a streaming consumer can reconstruct arguments
+// incrementally without re-parsing from scratch each time.
+func TestPushTextDeltasConcatenateToFullInput(t *testing.T) {
+	p := NewXmlStreamParser([]string{"mcp__fs__write"})
+
+	input := `{"path":"a.txt","content":"hello world"}`
+	var deltas []string
+	var sameID string
+	for _, chunk := range chunkString(`<mcp__fs__write>`+input+`</mcp__fs__write>`, 5) {
+		for _, r := range p.PushText(chunk) {
+			if r.Type == "tool_delta" {
+				deltas = append(deltas, r.PartialJSON)
+				if sameID == "" {
+					sameID = r.ID
+				} else if r.ID != sameID {
+					t.Errorf("tool_delta ID changed mid-call: %q vs %q", r.ID, sameID)
+				}
+			}
+		}
+	}
+
+	joined := strings.Join(deltas, "")
+	if !strings.HasPrefix(input, joined) {
+		t.Errorf("concatenated deltas %q is not a prefix of the full input %q", joined, input)
+	}
+}
+
+// TestAdvanceActiveHandlesMultiByteRunesAcrossPushes ensures the delta
+// tokenizer never splits a multi-byte UTF-8 rune across two PushText
+// calls, even when the split happens to land mid-rune.
+func TestAdvanceActiveHandlesMultiByteRunesAcrossPushes(t *testing.T) {
+	p := NewXmlStreamParser([]string{"mcp__fs__write"})
+
+	full := `<mcp__fs__write>{"content":"héllo wörld"}</mcp__fs__write>`
+	var deltas []string
+	for _, chunk := range chunkString(full, 3) {
+		for _, r := range p.PushText(chunk) {
+			if r.Type == "tool_delta" {
+				deltas = append(deltas, r.PartialJSON)
+			}
+		}
+	}
+
+	for _, d := range deltas {
+		if !utf8.ValidString(d) {
+			t.Fatalf("tool_delta chunk %q is not valid UTF-8 (rune split across pushes)", d)
+		}
+	}
+}
+
+func chunkString(s string, size int) []string {
+	var out []string
+	for len(s) > 0 {
+		n := size
+		if n > len(s) {
+			n = len(s)
+		}
+		out = append(out, s[:n])
+		s = s[n:]
+	}
+	return out
+}